@@ -1,53 +1,104 @@
 package lexer
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type TokenType int
 
 const (
 	TOKEN_EOF TokenType = iota
+	TOKEN_ILLEGAL
 	TOKEN_EOL
+	TOKEN_COMMENT
+	TOKEN_WHITESPACE
 	TOKEN_IDENTIFIER
+	TOKEN_VARIABLE
+	TOKEN_UNDERSCORE
 	TOKEN_NUMBER
 	TOKEN_FLOAT
 	TOKEN_STRING
 	TOKEN_BOOL
 	TOKEN_DATE
 	TOKEN_TIME
+	TOKEN_NULL
+	TOKEN_PERCENT
 
 	// Opérateurs
 	TOKEN_PLUS
 	TOKEN_MINUS
 	TOKEN_MULTIPLY
 	TOKEN_DIVIDE
+	TOKEN_MODULO
+	TOKEN_IDIV
+	TOKEN_POWER
 	TOKEN_ASSIGN
+	TOKEN_DECLARE
+	TOKEN_PLUS_ASSIGN
+	TOKEN_MINUS_ASSIGN
+	TOKEN_MULTIPLY_ASSIGN
+	TOKEN_DIVIDE_ASSIGN
+	TOKEN_INCREMENT
+	TOKEN_DECREMENT
 	TOKEN_EQUAL
 	TOKEN_NOT_EQUAL
 	TOKEN_LESS
 	TOKEN_LESS_EQUAL
+	TOKEN_SPACESHIP
 	TOKEN_GREATER
 	TOKEN_GREATER_EQUAL
 	TOKEN_IN
 	TOKEN_LIKE
 	TOKEN_BETWEEN
+	TOKEN_NOT_IN
+	TOKEN_NOT_LIKE
 	TOKEN_RARROW
+	// "<-" est reconnu dès que '-' suit immédiatement '<' : "a<-b" donne
+	// LARROW, alors que "a < -b" et "a< -b" donnent LESS puis MINUS. Un
+	// parseur qui préfère "a < -b" peut s'appuyer sur PrecededBySpace.
 	TOKEN_LARROW
+	TOKEN_FAT_ARROW
 	TOKEN_NOT
+	TOKEN_AND
+	TOKEN_OR
+	TOKEN_XOR
+	TOKEN_BIT_AND
+	TOKEN_BIT_OR
+	TOKEN_BIT_XOR
+	TOKEN_BIT_NOT
+	TOKEN_SHIFT_LEFT
+	TOKEN_SHIFT_RIGHT
+	TOKEN_QUESTION
+	TOKEN_PIPE
+	TOKEN_RANGE
+	TOKEN_ELLIPSIS
+	TOKEN_HASH
 
 	// Délimiteurs
 	TOKEN_LPAREN
 	TOKEN_RPAREN
-	// TOKEN_LBRACE
-	// TOKEN_RBRACE
+	TOKEN_LBRACE
+	TOKEN_RBRACE
 	TOKEN_LBRACKET
 	TOKEN_RBRACKET
 	TOKEN_SEMICOLON
 	TOKEN_COLON
+	TOKEN_DOUBLE_COLON
 	TOKEN_COMMA
 	TOKEN_DOT
+	TOKEN_AT
+	TOKEN_BACKSLASH
+	TOKEN_BACKTICK
+	TOKEN_DOLLAR
+	TOKEN_INTERP_START
 
 	// Mots-clés
 	TOKEN_IF
@@ -78,15 +129,241 @@ const (
 	TOKEN_RECURSIVE
 	TOKEN_BROWSE
 	TOKEN_CASE
+	TOKEN_IS
+	TOKEN_AS
+	TOKEN_THEN
+
+	// Inutilisés : "true" et "false" donnent TOKEN_BOOL. Conservés pour la
+	// compatibilité, hors du bloc des mots-clés.
 	TOKEN_TRUE
 	TOKEN_FALSE
 )
 
+var tokenNames = [...]string{
+	TOKEN_EOF:        "EOF",
+	TOKEN_ILLEGAL:    "ILLEGAL",
+	TOKEN_EOL:        "EOL",
+	TOKEN_COMMENT:    "COMMENT",
+	TOKEN_WHITESPACE: "WHITESPACE",
+	TOKEN_IDENTIFIER: "IDENTIFIER",
+	TOKEN_VARIABLE:   "VARIABLE",
+	TOKEN_UNDERSCORE: "UNDERSCORE",
+	TOKEN_NUMBER:     "NUMBER",
+	TOKEN_FLOAT:      "FLOAT",
+	TOKEN_STRING:     "STRING",
+	TOKEN_BOOL:       "BOOL",
+	TOKEN_DATE:       "DATE",
+	TOKEN_TIME:       "TIME",
+	TOKEN_NULL:       "NULL",
+	TOKEN_PERCENT:    "PERCENT",
+
+	// Opérateurs
+	TOKEN_PLUS:            "PLUS",
+	TOKEN_MINUS:           "MINUS",
+	TOKEN_MULTIPLY:        "MULTIPLY",
+	TOKEN_DIVIDE:          "DIVIDE",
+	TOKEN_MODULO:          "MODULO",
+	TOKEN_IDIV:            "IDIV",
+	TOKEN_POWER:           "POWER",
+	TOKEN_ASSIGN:          "ASSIGN",
+	TOKEN_DECLARE:         "DECLARE",
+	TOKEN_PLUS_ASSIGN:     "PLUS_ASSIGN",
+	TOKEN_MINUS_ASSIGN:    "MINUS_ASSIGN",
+	TOKEN_MULTIPLY_ASSIGN: "MULTIPLY_ASSIGN",
+	TOKEN_DIVIDE_ASSIGN:   "DIVIDE_ASSIGN",
+	TOKEN_INCREMENT:       "INCREMENT",
+	TOKEN_DECREMENT:       "DECREMENT",
+	TOKEN_EQUAL:           "EQUAL",
+	TOKEN_NOT_EQUAL:       "NOT_EQUAL",
+	TOKEN_LESS:            "LESS",
+	TOKEN_LESS_EQUAL:      "LESS_EQUAL",
+	TOKEN_SPACESHIP:       "SPACESHIP",
+	TOKEN_GREATER:         "GREATER",
+	TOKEN_GREATER_EQUAL:   "GREATER_EQUAL",
+	TOKEN_IN:              "IN",
+	TOKEN_LIKE:            "LIKE",
+	TOKEN_BETWEEN:         "BETWEEN",
+	TOKEN_NOT_IN:          "NOT_IN",
+	TOKEN_NOT_LIKE:        "NOT_LIKE",
+	TOKEN_RARROW:          "RARROW",
+	TOKEN_LARROW:          "LARROW",
+	TOKEN_FAT_ARROW:       "FAT_ARROW",
+	TOKEN_NOT:             "NOT",
+	TOKEN_AND:             "AND",
+	TOKEN_OR:              "OR",
+	TOKEN_XOR:             "XOR",
+	TOKEN_BIT_AND:         "BIT_AND",
+	TOKEN_BIT_OR:          "BIT_OR",
+	TOKEN_BIT_XOR:         "BIT_XOR",
+	TOKEN_BIT_NOT:         "BIT_NOT",
+	TOKEN_SHIFT_LEFT:      "SHIFT_LEFT",
+	TOKEN_SHIFT_RIGHT:     "SHIFT_RIGHT",
+	TOKEN_QUESTION:        "QUESTION",
+	TOKEN_PIPE:            "PIPE",
+	TOKEN_RANGE:           "RANGE",
+	TOKEN_ELLIPSIS:        "ELLIPSIS",
+	TOKEN_HASH:            "HASH",
+
+	// Délimiteurs
+	TOKEN_LPAREN:       "LPAREN",
+	TOKEN_RPAREN:       "RPAREN",
+	TOKEN_LBRACE:       "LBRACE",
+	TOKEN_RBRACE:       "RBRACE",
+	TOKEN_LBRACKET:     "LBRACKET",
+	TOKEN_RBRACKET:     "RBRACKET",
+	TOKEN_SEMICOLON:    "SEMICOLON",
+	TOKEN_COLON:        "COLON",
+	TOKEN_DOUBLE_COLON: "DOUBLE_COLON",
+	TOKEN_COMMA:        "COMMA",
+	TOKEN_DOT:          "DOT",
+	TOKEN_AT:           "AT",
+	TOKEN_BACKSLASH:    "BACKSLASH",
+	TOKEN_BACKTICK:     "BACKTICK",
+	TOKEN_DOLLAR:       "DOLLAR",
+	TOKEN_INTERP_START: "INTERP_START",
+
+	// Mots-clés
+	TOKEN_IF:          "IF",
+	TOKEN_ELSE:        "ELSE",
+	TOKEN_WHILE:       "WHILE",
+	TOKEN_FOR:         "FOR",
+	TOKEN_FOREACH:     "FOREACH",
+	TOKEN_FUNCTION:    "FUNCTION",
+	TOKEN_RETURN:      "RETURN",
+	TOKEN_LET:         "LET",
+	TOKEN_TYPE:        "TYPE",
+	TOKEN_RECORD:      "RECORD",
+	TOKEN_ACTION:      "ACTION",
+	TOKEN_START:       "START",
+	TOKEN_END:         "END",
+	TOKEN_DO:          "DO",
+	TOKEN_STOP:        "STOP",
+	TOKEN_NUMBER_TYPE: "NUMBER_TYPE",
+	TOKEN_FLOAT_TYPE:  "FLOAT_TYPE",
+	TOKEN_STRING_TYPE: "STRING_TYPE",
+	TOKEN_BOOL_TYPE:   "BOOL_TYPE",
+	TOKEN_DATE_TYPE:   "DATE_TYPE",
+	TOKEN_TIME_TYPE:   "TIME_TYPE",
+	TOKEN_ARRAY:       "ARRAY",
+	TOKEN_SELECT:      "SELECT",
+	TOKEN_FROM:        "FROM",
+	TOKEN_WHERE:       "WHERE",
+	TOKEN_RECURSIVE:   "RECURSIVE",
+	TOKEN_BROWSE:      "BROWSE",
+	TOKEN_CASE:        "CASE",
+	TOKEN_IS:          "IS",
+	TOKEN_AS:          "AS",
+	TOKEN_THEN:        "THEN",
+	TOKEN_TRUE:        "TRUE",
+	TOKEN_FALSE:       "FALSE",
+}
+
+// String renvoie le nom lisible du type de token (TOKEN_PLUS -> "PLUS").
+func (t TokenType) String() string {
+	if t >= 0 && int(t) < len(tokenNames) && tokenNames[t] != "" {
+		return tokenNames[t]
+	}
+	return fmt.Sprintf("TokenType(%d)", int(t))
+}
+
+// Les méthodes Is* s'appuient sur le regroupement des constantes : un
+// nouveau type de token doit être déclaré dans le bloc qui lui correspond.
+
+// IsKeyword indique si t est un mot-clé (bloc "Mots-clés"), y compris les
+// mots-clés de type.
+func (t TokenType) IsKeyword() bool {
+	return t >= TOKEN_IF && t < TOKEN_TRUE
+}
+
+// IsTypeKeyword indique si t est un mot-clé de type (NUMBER_TYPE à
+// TIME_TYPE, et ARRAY).
+func (t TokenType) IsTypeKeyword() bool {
+	return t >= TOKEN_NUMBER_TYPE && t <= TOKEN_ARRAY
+}
+
+// IsOperator indique si t est un opérateur (bloc "Opérateurs").
+func (t TokenType) IsOperator() bool {
+	return t >= TOKEN_PLUS && t < TOKEN_LPAREN
+}
+
+// IsLiteral indique si t est un littéral (NUMBER à PERCENT) : nombre
+// entier ou décimal, chaîne, booléen, date, heure, null ou pourcentage.
+func (t TokenType) IsLiteral() bool {
+	return t >= TOKEN_NUMBER && t < TOKEN_PLUS
+}
+
 type Token struct {
-	Type   TokenType
+	Type TokenType
+	// Value est le texte du token. Pour les opérateurs, c'est la graphie
+	// de l'entrée : "<>" et "!=" donnent tous deux TOKEN_NOT_EQUAL et ne se
+	// distinguent que par Value.
 	Value  string
 	Line   int
 	Column int
+	// EndLine et EndColumn repèrent la position qui suit immédiatement le
+	// dernier caractère du token.
+	EndLine   int
+	EndColumn int
+	// StartOffset et EndOffset délimitent le texte source du token, en
+	// octets : input[StartOffset:EndOffset].
+	StartOffset int
+	EndOffset   int
+	// Quote est le délimiteur d'ouverture d'une chaîne ('"' ou '\''),
+	// 0 pour les autres tokens.
+	Quote byte
+	// PrecededBySpace indique que des blancs ou des commentaires séparent ce
+	// token du précédent : "f (x)" plutôt que "f(x)".
+	PrecededBySpace bool
+	// Raw signale une chaîne brute (r"..."), dont Value est le texte exact,
+	// sans interprétation des '\'.
+	Raw bool
+}
+
+// MarshalJSON encode le token avec le nom de son type ("IDENTIFIER") plutôt
+// que sa valeur numérique, accompagné de Value, Line et Column.
+func (t Token) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type   string
+		Value  string
+		Line   int
+		Column int
+	}{t.Type.String(), t.Value, t.Line, t.Column})
+}
+
+// LexError décrit un problème rencontré pendant l'analyse lexicale, à la
+// position où commence le texte fautif.
+type LexError struct {
+	Message string
+	// Filename est le nom donné par SetFilename, vide par défaut.
+	Filename string
+	Line     int
+	Column   int
+	// Warning distingue un simple avertissement, qui n'empêche pas de
+	// produire des tokens valides, d'une véritable erreur.
+	Warning bool
+	// Err est la cause sous-jacente, par exemple l'erreur du io.Reader
+	// d'un lexer NewLexerReader ; nil pour les erreurs lexicales.
+	Err error
+}
+
+// Error met l'erreur sous la forme "lex error at 3:5: unterminated
+// string", pour que LexError s'utilise comme une error.
+func (e LexError) Error() string {
+	return "lex error at " + e.String()
+}
+
+// Unwrap renvoie Err, pour errors.Is et errors.As.
+func (e LexError) Unwrap() error {
+	return e.Err
+}
+
+// String met l'erreur sous la forme "fichier:ligne:colonne: message", sans
+// le nom de fichier s'il n'a pas été fourni.
+func (e LexError) String() string {
+	if e.Filename == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.Filename, e.Line, e.Column, e.Message)
 }
 
 type Lexer struct {
@@ -94,38 +371,635 @@ type Lexer struct {
 	pos    int
 	line   int
 	column int
+	// start est le décalage du début du token en cours d'analyse.
+	start int
+	// tabWidth est l'écart entre deux taquets de tabulation (SetTabWidth) ;
+	// 0 ou 1 compte une tabulation comme une colonne.
+	tabWidth int
+	// maxTokenLength borne la longueur d'un token en octets (0 : illimitée).
+	maxTokenLength int
+	// truncated signale que readDigits s'est arrêté sur tooLong.
+	truncated bool
+	// coalesceNot réunit "not in" et "not like" en un seul token.
+	coalesceNot bool
+	// underscoreWildcard rend un '_' isolé en TOKEN_UNDERSCORE.
+	underscoreWildcard bool
+	// dollarVariables reconnaît les variables "$nom".
+	dollarVariables bool
+	// lenient accepte "=<" pour "<=".
+	lenient bool
+	// checkIndent signale les indentations mêlant tabulations et espaces.
+	checkIndent bool
+	// numberSuffixes liste les suffixes d'unité acceptés après un nombre.
+	numberSuffixes []string
+	// strictNumbers rejette les nombres collés à une lettre.
+	strictNumbers bool
+	// filename est repris dans les erreurs (SetFilename).
+	filename string
+	// src alimente input au fur et à mesure lorsque le lexer lit un flux
+	// (NewLexerReader) ; nil pour une entrée fournie en entier.
+	src *readerSource
+	// keywords contient les mots-clés ajoutés par AddKeyword, en minuscules.
+	keywords map[string]TokenType
+	emitEOL  bool
+	// emitComments rend les commentaires en TOKEN_COMMENT.
+	emitComments bool
+	// emitWhitespace rend chaque caractère de l'entrée dans un token
+	// (SetEmitWhitespace).
+	emitWhitespace bool
+	// caseSensitive restreint les mots-clés à leur forme en minuscules.
+	caseSensitive bool
+	// Délimiteurs de commentaires ; une chaîne vide désactive la forme
+	// correspondante.
+	lineComment string
+	blockOpen   string
+	blockClose  string
+	errors      []LexError
+	// hook est appelée pour chaque token rendu par NextToken (SetTokenHook).
+	hook func(Token)
+	// lookahead contient les tokens déjà lus par PeekAt et pas encore
+	// rendus par NextToken.
+	lookahead []pendingToken
+	// values reçoit, pendant NextTokenInto (shareValues), les Value qui ne
+	// sont pas des sous-chaînes de l'entrée ; voir valueBuilder.
+	values      strings.Builder
+	shareValues bool
+}
+
+// pendingToken est un token d'avance, avec l'état du lexer avant sa
+// lecture : Position et Errors ne reflètent que les tokens rendus.
+type pendingToken struct {
+	token             Token
+	pos, line, column int
+	errors            int
+}
+
+// readerSource accumule le contenu lu depuis un io.Reader. Les octets déjà
+// lus ne sont jamais modifiés ni libérés, si bien que les Value des tokens
+// restent valides pendant que le tampon grandit.
+type readerSource struct {
+	r     io.Reader
+	buf   strings.Builder
+	chunk []byte
+	// err est l'erreur qui a arrêté la lecture, rencontrée après le dernier
+	// octet du tampon. Chaque lexer qui partage le flux (Clone) la signale
+	// une fois, en arrivant à ce point (voir readErrorReported).
+	err error
 }
 
+const readChunkSize = 4096
+
+// valueBlockSize est la taille des blocs où NextTokenInto construit les Value.
+const valueBlockSize = 4096
+
 func NewLexer(input string) *Lexer {
 	return &Lexer{
-		input:  input,
-		pos:    0,
-		line:   1,
-		column: 1,
+		input:       input,
+		pos:         0,
+		line:        1,
+		column:      1,
+		lineComment: "//",
+		blockOpen:   "(*",
+		blockClose:  "*)",
+	}
+}
+
+// NewLexerReader crée un lexer qui lit son entrée depuis r au fur et à
+// mesure des besoins, par blocs, au lieu d'exiger tout le texte d'avance.
+// Il produit les mêmes tokens que NewLexer sur le même contenu.
+//
+// Tout le contenu lu reste en mémoire tant que le lexer est utilisé : les
+// Value des tokens, Snapshot, Restore et Clone y font référence. La mémoire
+// occupée finit donc par atteindre la taille du flux, comme avec io.ReadAll ;
+// l'intérêt est de pouvoir analyser le début d'un flux (tube, connexion
+// réseau) avant d'en avoir reçu la fin, pas d'analyser un flux plus gros que
+// la mémoire disponible.
+func NewLexerReader(r io.Reader) *Lexer {
+	return &Lexer{
+		line:        1,
+		column:      1,
+		src:         &readerSource{r: r, chunk: make([]byte, readChunkSize)},
+		lineComment: "//",
+		blockOpen:   "(*",
+		blockClose:  "*)",
+	}
+}
+
+// NewLexerFromFile lit le fichier path et renvoie un lexer sur son contenu,
+// avec path comme nom de fichier dans les erreurs (voir SetFilename).
+// L'erreur de lecture est renvoyée telle quelle, par exemple pour un
+// fichier inexistant.
+func NewLexerFromFile(path string) (*Lexer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	l := NewLexer(string(data))
+	l.SetFilename(path)
+	return l, nil
+}
+
+// Reset réinitialise le lexer sur une nouvelle entrée afin de le
+// réutiliser sans nouvelle allocation. Le nom de fichier est effacé, les
+// options sont conservées.
+func (l *Lexer) Reset(input string) {
+	l.input = input
+	l.pos = 0
+	l.line = 1
+	l.column = 1
+	l.src = nil
+	l.filename = ""
+	l.errors = nil
+	l.lookahead = nil
+}
+
+// Clone renvoie une copie indépendante du lexer, pour analyser en avance
+// puis abandonner la copie sans affecter l'original. L'entrée, immuable,
+// est partagée (y compris le tampon d'un lexer NewLexerReader), ce qui
+// rend la copie peu coûteuse.
+func (l *Lexer) Clone() *Lexer {
+	c := *l
+	c.values = strings.Builder{}
+	c.errors = append([]LexError(nil), l.errors...)
+	c.lookahead = append([]pendingToken(nil), l.lookahead...)
+	if l.keywords != nil {
+		c.keywords = make(map[string]TokenType, len(l.keywords))
+		for word, t := range l.keywords {
+			c.keywords[word] = t
+		}
+	}
+	return &c
+}
+
+// LexerState est une position enregistrée par Snapshot.
+type LexerState struct {
+	pos, line, column int
+	errors            int
+	truncated         bool
+	lookahead         []pendingToken
+}
+
+// Snapshot enregistre la position du lexer, tokens lus d'avance compris,
+// pour y revenir avec Restore. C'est une alternative légère à Clone pour
+// essayer une analyse puis revenir en arrière.
+func (l *Lexer) Snapshot() LexerState {
+	return LexerState{
+		pos:       l.pos,
+		line:      l.line,
+		column:    l.column,
+		errors:    len(l.errors),
+		truncated: l.truncated,
+		lookahead: append([]pendingToken(nil), l.lookahead...),
+	}
+}
+
+// Restore ramène le lexer à une position enregistrée par Snapshot sur la
+// même entrée ; les erreurs rencontrées depuis sont oubliées. Les erreurs
+// conservées sont recopiées, pour que les suivantes n'écrasent pas une liste
+// déjà rendue par Errors.
+func (l *Lexer) Restore(s LexerState) {
+	l.pos, l.line, l.column = s.pos, s.line, s.column
+	l.truncated = s.truncated
+	l.errors = append([]LexError(nil), l.errors[:min(s.errors, len(l.errors))]...)
+	l.lookahead = append(l.lookahead[:0], s.lookahead...)
+}
+
+// Errors renvoie les erreurs rencontrées jusqu'ici. Le lexer ne s'arrête
+// pas sur une erreur : le texte fautif est renvoyé en TOKEN_ILLEGAL et
+// l'analyse continue.
+func (l *Lexer) Errors() []LexError {
+	if len(l.lookahead) > 0 {
+		return l.errors[:l.lookahead[0].errors]
+	}
+	return l.errors
+}
+
+func (l *Lexer) addError(line, column int, format string, args ...any) {
+	l.errors = append(l.errors, LexError{
+		Message:  fmt.Sprintf(format, args...),
+		Filename: l.filename,
+		Line:     line,
+		Column:   column,
+	})
+}
+
+func (l *Lexer) addWarning(line, column int, format string, args ...any) {
+	l.addError(line, column, format, args...)
+	l.errors[len(l.errors)-1].Warning = true
+}
+
+// SetTokenHook installe fn, appelée avec chaque token que NextToken (et donc
+// Tokenize ou All) s'apprête à renvoyer. Un token lu d'avance par PeekAt
+// n'est signalé qu'une fois, quand NextToken le rend. nil retire le hook.
+func (l *Lexer) SetTokenHook(fn func(Token)) {
+	l.hook = fn
+}
+
+// SetFilename associe un nom de fichier à l'entrée, repris dans les
+// erreurs afin qu'elles se lisent "fichier.dsl:12:4: message".
+func (l *Lexer) SetFilename(name string) {
+	l.filename = name
+}
+
+// SetNumberSuffixes déclare les suffixes d'unité acceptés juste après un
+// nombre, par exemple "k", "M" et "G" : "10k" et "1.5M" donnent alors un
+// seul TOKEN_NUMBER ou TOKEN_FLOAT dont Value comprend le suffixe. La
+// comparaison tient compte de la casse ("m" et "M" sont distincts) et un
+// suffixe suivi d'une lettre ("10kg") n'est pas reconnu. Sans suffixe
+// déclaré, le cas par défaut, "10k" donne un nombre puis un identifiant.
+func (l *Lexer) SetNumberSuffixes(suffixes ...string) {
+	l.numberSuffixes = append([]string(nil), suffixes...)
+}
+
+// SetStrictNumbers active le rejet des nombres immédiatement suivis d'une
+// lettre, d'un chiffre ou d'un '_' qui n'en font pas partie : "123abc" ou
+// "0b102" donnent alors un TOKEN_ILLEGAL portant tout le mot et une
+// erreur, au lieu d'un nombre suivi d'un autre token. Le mode est
+// désactivé par défaut.
+func (l *Lexer) SetStrictNumbers(strict bool) {
+	l.strictNumbers = strict
+}
+
+// SetTabWidth fait avancer une tabulation jusqu'au taquet suivant, les
+// taquets étant espacés de width colonnes, pour que les colonnes
+// correspondent à l'affichage d'un éditeur. Par défaut (width <= 1), une
+// tabulation compte pour une colonne.
+func (l *Lexer) SetTabWidth(width int) {
+	l.tabWidth = width
+}
+
+// SetMaxTokenLength borne à n octets la longueur d'un token : identifiant,
+// nombre, chaîne, date ou commentaire. Un token plus long est rendu en
+// TOKEN_ILLEGAL avec une erreur, Value étant réduite à ses n premiers
+// octets, au lieu de construire une valeur géante (chaîne non fermée dans un
+// texte énorme, par exemple). Un identifiant ou un nombre est coupé à n
+// octets et l'analyse reprend juste après ; une chaîne, une date ou un
+// commentaire est parcouru jusqu'à son délimiteur fermant, ou la fin de
+// l'entrée, pour ne pas reprendre au milieu. Un commentaire ignoré est
+// signalé de la même façon. La valeur 0, par défaut, ne fixe aucune limite.
+func (l *Lexer) SetMaxTokenLength(n int) {
+	l.maxTokenLength = n
+}
+
+// SetNegatedOperators réunit "not in" et "not like" en un seul token,
+// TOKEN_NOT_IN ou TOKEN_NOT_LIKE, dont Value reprend le texte d'origine
+// avec ses blancs ("NOT  IN"). Le mode est désactivé par défaut.
+func (l *Lexer) SetNegatedOperators(enable bool) {
+	l.coalesceNot = enable
+}
+
+// SetUnderscoreWildcard fait d'un '_' isolé un TOKEN_UNDERSCORE (joker des
+// motifs) plutôt qu'un identifiant ; "_x" et "a_b" restent des
+// identifiants. Le mode est désactivé par défaut.
+func (l *Lexer) SetUnderscoreWildcard(enable bool) {
+	l.underscoreWildcard = enable
+}
+
+// SetDollarVariables active la reconnaissance des variables "$nom", rendues
+// en TOKEN_VARIABLE avec le '$' dans Value. Hors de ce mode, "$nom" donne
+// TOKEN_DOLLAR puis un identifiant.
+func (l *Lexer) SetDollarVariables(enable bool) {
+	l.dollarVariables = enable
+}
+
+// SetLenientComparisons rend "=<" en TOKEN_LESS_EQUAL, avec un
+// avertissement dans Errors, au lieu de TOKEN_ASSIGN suivi de TOKEN_LESS.
+// Value garde la graphie "=<". Le mode est désactivé par défaut.
+func (l *Lexer) SetLenientComparisons(lenient bool) {
+	l.lenient = lenient
+}
+
+// SetCheckIndentation active le signalement, dans Errors et avec Warning à
+// true, des lignes dont l'indentation mêle tabulations et espaces. Le mode
+// est désactivé par défaut.
+func (l *Lexer) SetCheckIndentation(check bool) {
+	l.checkIndent = check
+}
+
+// SetEmitComments active l'émission d'un TOKEN_COMMENT pour chaque
+// commentaire, au lieu de l'ignorer. Value contient le texte complet du
+// commentaire, délimiteurs compris ("(* doc *)", "// note"), sans la fin de
+// ligne qui suit un commentaire de ligne. Comme une chaîne, un commentaire de
+// bloc non fermé donne TOKEN_ILLEGAL. Le mode est désactivé par défaut.
+func (l *Lexer) SetEmitComments(emit bool) {
+	l.emitComments = emit
+}
+
+// SetEmitEOL active ou désactive l'émission d'un TOKEN_EOL pour chaque fin
+// de ligne ("\n", "\r\n" ou "\r"), au lieu de la traiter comme un blanc. Le mode
+// est désactivé par défaut.
+func (l *Lexer) SetEmitEOL(emit bool) {
+	l.emitEOL = emit
+}
+
+// SetEmitWhitespace active le mode fidèle : chaque suite d'espaces et de
+// tabulations est rendue en TOKEN_WHITESPACE, chaque fin de ligne en
+// TOKEN_EOL et chaque commentaire en TOKEN_COMMENT. Value contient alors le
+// texte exact du token dans la source (guillemets et échappements compris
+// pour les chaînes), de sorte que la concaténation des Value reconstitue
+// l'entrée à l'octet près. Le mode est désactivé par défaut.
+func (l *Lexer) SetEmitWhitespace(emit bool) {
+	l.emitWhitespace = emit
+}
+
+// SetLineComment remplace le délimiteur des commentaires de fin de ligne
+// ("//" par défaut), par exemple par "--" ou ";". Une chaîne vide désactive
+// ces commentaires. Les commentaires étant reconnus avant tout autre token,
+// un délimiteur comme "#" masque les dates et heures "#2024-01-15#" et
+// TOKEN_HASH, lus alors comme des commentaires ; de même pour SetBlockComment.
+func (l *Lexer) SetLineComment(start string) {
+	l.lineComment = start
+}
+
+// SetBlockComment remplace les délimiteurs des commentaires de bloc ("(*"
+// et "*)" par défaut). Des délimiteurs identiques ("'''" et "'''") sont
+// admis : le commentaire se ferme alors à la première occurrence suivante et
+// ne peut pas être imbriqué. Une chaîne vide pour open ou pour close
+// désactive ces commentaires.
+func (l *Lexer) SetBlockComment(open, close string) {
+	if open == "" || close == "" {
+		open, close = "", ""
+	}
+	l.blockOpen = open
+	l.blockClose = close
+}
+
+// SetKeywordsCaseSensitive rend la reconnaissance des mots-clés sensible à
+// la casse : seules les formes en minuscules ("select", pas "Select" ni
+// "SELECT") sont alors des mots-clés, y compris pour ceux ajoutés par
+// AddKeyword. Le mode est désactivé par défaut.
+func (l *Lexer) SetKeywordsCaseSensitive(sensitive bool) {
+	l.caseSensitive = sensitive
+}
+
+// Position renvoie la position courante du lexer : ligne et colonne (à
+// partir de 1) et décalage en octets dans l'entrée.
+func (l *Lexer) Position() (line, column, offset int) {
+	if len(l.lookahead) > 0 {
+		p := l.lookahead[0]
+		return p.line, p.column, p.pos
+	}
+	return l.line, l.column, l.pos
+}
+
+// Tokenize lit l'entrée jusqu'à la fin et renvoie tous les tokens,
+// y compris le TOKEN_EOF final.
+func (l *Lexer) Tokenize() []Token {
+	var tokens []Token
+	for {
+		token := l.NextToken()
+		tokens = append(tokens, token)
+		if token.Type == TOKEN_EOF {
+			return tokens
+		}
+	}
+}
+
+// TokensOfType lit l'entrée jusqu'à la fin et ne renvoie que les tokens de
+// type t, sans construire la liste complète.
+func (l *Lexer) TokensOfType(t TokenType) []Token {
+	var tokens []Token
+	for token := range l.All() {
+		if token.Type == t {
+			tokens = append(tokens, token)
+		}
 	}
+	return tokens
+}
+
+// TokensToString reconstitue un texte source à partir de tokens : les
+// valeurs sont séparées par une espace, sauf autour des délimiteurs qui
+// n'en demandent pas ("f(a, b)", "r.x", "t[0]"). Les chaînes sont remises
+// entre leurs délimiteurs avec leurs échappements, les dates et heures
+// entre '#'. Les blancs et commentaires d'origine ne sont pas restitués.
+func TokensToString(tokens []Token) string {
+	var sb strings.Builder
+	var prev TokenType = TOKEN_EOL
+	for _, t := range tokens {
+		if t.Type == TOKEN_EOF {
+			break
+		}
+		if t.Type == TOKEN_COMMENT {
+			continue
+		}
+		if spaceBetween(prev, t.Type) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(tokenText(t))
+		prev = t.Type
+	}
+	return sb.String()
+}
+
+// spaceBetween indique si TokensToString sépare deux tokens consécutifs
+// par une espace.
+func spaceBetween(prev, next TokenType) bool {
+	switch prev {
+	case TOKEN_EOL, TOKEN_LPAREN, TOKEN_LBRACKET, TOKEN_DOT, TOKEN_DOUBLE_COLON,
+		TOKEN_RANGE, TOKEN_AT, TOKEN_BACKSLASH, TOKEN_HASH, TOKEN_DOLLAR, TOKEN_INTERP_START:
+		return false
+	}
+	switch next {
+	case TOKEN_EOL, TOKEN_RPAREN, TOKEN_RBRACKET, TOKEN_COMMA, TOKEN_SEMICOLON,
+		TOKEN_COLON, TOKEN_DOT, TOKEN_DOUBLE_COLON, TOKEN_RANGE, TOKEN_ELLIPSIS:
+		return false
+	case TOKEN_LPAREN, TOKEN_LBRACKET:
+		// Appel ou indexation : "f(a)", "t[0]", "m[i][j]"
+		return prev != TOKEN_IDENTIFIER && prev != TOKEN_RPAREN && prev != TOKEN_RBRACKET
+	}
+	return true
+}
+
+// tokenText renvoie le texte source d'un token, délimiteurs compris.
+func tokenText(t Token) string {
+	switch t.Type {
+	case TOKEN_STRING:
+		quote := t.Quote
+		if quote == 0 {
+			quote = '"'
+		}
+		if t.Raw {
+			return "r" + string(quote) + t.Value + string(quote)
+		}
+		var sb strings.Builder
+		sb.WriteByte(quote)
+		for i := 0; i < len(t.Value); i++ {
+			switch ch := t.Value[i]; ch {
+			case '\n':
+				sb.WriteString(`\n`)
+			case '\t':
+				sb.WriteString(`\t`)
+			case '\r':
+				sb.WriteString(`\r`)
+			case '\\', quote:
+				sb.WriteByte('\\')
+				sb.WriteByte(ch)
+			default:
+				sb.WriteByte(ch)
+			}
+		}
+		sb.WriteByte(quote)
+		return sb.String()
+	case TOKEN_DATE, TOKEN_TIME:
+		return "#" + t.Value + "#"
+	}
+	return t.Value
+}
+
+// All renvoie une séquence des tokens de l'entrée, à parcourir avec
+// range ; elle s'arrête après avoir produit TOKEN_EOF.
+func (l *Lexer) All() iter.Seq[Token] {
+	return func(yield func(Token) bool) {
+		for {
+			token := l.NextToken()
+			if !yield(token) || token.Type == TOKEN_EOF {
+				return
+			}
+		}
+	}
+}
+
+// PeekToken renvoie le token que retournerait le prochain appel à
+// NextToken, sans faire avancer le lexer. C'est PeekAt(0).
+func (l *Lexer) PeekToken() Token {
+	return l.PeekAt(0)
+}
+
+// PeekAt renvoie le token situé n positions plus loin (0 pour le prochain)
+// sans faire avancer le lexer. Les tokens lus d'avance sont conservés et
+// rendus ensuite par NextToken : chaque token n'est analysé qu'une fois.
+// Au-delà de la fin, PeekAt renvoie TOKEN_EOF ; un n négatif compte pour 0.
+func (l *Lexer) PeekAt(n int) Token {
+	n = max(n, 0)
+	for len(l.lookahead) <= n {
+		p := pendingToken{pos: l.pos, line: l.line, column: l.column, errors: len(l.errors)}
+		p.token = l.scan()
+		l.lookahead = append(l.lookahead, p)
+	}
+	return l.lookahead[n].token
 }
 
 func (l *Lexer) NextToken() Token {
-	l.skipWhitespace()
+	var token Token
+	if len(l.lookahead) > 0 {
+		// Décalage plutôt que l.lookahead[1:], pour réutiliser le tableau
+		token = l.lookahead[0].token
+		l.lookahead = l.lookahead[:copy(l.lookahead, l.lookahead[1:])]
+	} else {
+		token = l.scan()
+	}
+	if l.hook != nil {
+		l.hook(token)
+	}
+	return token
+}
+
+// PeekRune renvoie le caractère à la position courante et sa taille en
+// octets, sans rien consommer, ou (0, 0) en fin d'entrée. Les blancs et
+// commentaires ne sont pas sautés. Après un PeekAt, la position courante
+// reste celle qui précède le premier token lu d'avance.
+func (l *Lexer) PeekRune() (rune, int) {
+	if len(l.lookahead) > 0 {
+		pos := l.lookahead[0].pos
+		if pos >= len(l.input) {
+			return 0, 0
+		}
+		return utf8.DecodeRuneInString(l.input[pos:])
+	}
+	return l.currentRune()
+}
+
+// NextTokenInto lit le prochain token dans *tok, que l'appelant peut
+// réutiliser d'un appel à l'autre. Value y est, comme pour NextToken, une
+// sous-chaîne de l'entrée, sans copie ni allocation. Les Value qui ne le
+// sont pas (chaîne contenant des échappements, nombre écrit avec des '_')
+// sont construites à la suite dans des blocs de quelques Ko partagés, au
+// lieu d'une allocation chacune : elles restent valides, mais une Value
+// conservée garde son bloc en mémoire. Elle renvoie false une fois
+// TOKEN_EOF atteint, tok contenant alors ce TOKEN_EOF.
+func (l *Lexer) NextTokenInto(tok *Token) bool {
+	l.shareValues = true
+	*tok = l.NextToken()
+	l.shareValues = false
+	return tok.Type != TOKEN_EOF
+}
 
-	if l.pos >= len(l.input) {
-		return Token{Type: TOKEN_EOF, Line: l.line, Column: l.column}
+// valueBuilder renvoie le tampon où construire une Value qui n'est pas une
+// sous-chaîne de l'entrée : local, propre au token, ou pendant NextTokenInto
+// le bloc partagé values. La Value est prise avec sb.String()[mark:], mark
+// étant sb.Len() avant l'écriture ; les octets déjà écrits dans un bloc ne
+// sont jamais modifiés, si bien que les Value précédentes restent valides.
+func (l *Lexer) valueBuilder(local *strings.Builder) *strings.Builder {
+	if !l.shareValues {
+		return local
+	}
+	if l.values.Len() >= valueBlockSize {
+		l.values = strings.Builder{}
 	}
+	if l.values.Cap() == 0 {
+		l.values.Grow(valueBlockSize)
+	}
+	return &l.values
+}
 
-	// Commentaires
-	if l.input[l.pos] == '(' && l.peek() == '*' {
-		l.skipComment()
+func (l *Lexer) scan() Token {
+	begin := l.pos
+	token := l.scanToken()
+	token.PrecededBySpace = l.start > begin
+	token.EndLine, token.EndColumn = l.line, l.column
+	token.StartOffset, token.EndOffset = l.start, l.pos
+	if l.emitWhitespace {
+		token.Value = l.input[l.start:l.pos]
 	}
+	return token
+}
 
-	ch := l.input[l.pos]
+func (l *Lexer) scanToken() Token {
+	for {
+		l.skipWhitespace()
+		l.start = l.pos
+
+		if !l.available(1) {
+			return Token{Type: TOKEN_EOF, Line: l.line, Column: l.column}
+		}
+
+		// Commentaires, rendus en TOKEN_COMMENT en mode SetEmitComments ou
+		// SetEmitWhitespace
+		block := l.hasPrefix(l.blockOpen)
+		if !block && !l.hasPrefix(l.lineComment) {
+			break
+		}
+		if token := l.readComment(block); l.emitComments || l.emitWhitespace {
+			return token
+		}
+	}
+
+	ch, size := l.currentRune()
+
+	if l.emitWhitespace && (ch == ' ' || ch == '\t') {
+		return l.readWhitespace()
+	}
+
+	// Chaînes brutes : r"..." ou r'...'
+	if ch == 'r' && (l.peek() == '"' || l.peek() == '\'') {
+		return l.readRawString()
+	}
 
 	// Identifiants et mots-clés
-	if unicode.IsLetter(rune(ch)) || ch == '_' {
+	if unicode.IsLetter(ch) || ch == '_' {
 		return l.readIdentifier()
 	}
 
+	// Variables "$nom" en mode SetDollarVariables
+	if ch == '$' && l.dollarVariables {
+		l.available(1 + utf8.UTFMax)
+		if next, _ := utf8.DecodeRuneInString(l.input[l.pos+1:]); unicode.IsLetter(next) || next == '_' {
+			return l.readVariable()
+		}
+	}
+
 	// Nombres
-	if unicode.IsDigit(rune(ch)) {
+	if isDecimalDigit(l.input[l.pos]) {
 		return l.readNumber()
 	}
 
@@ -134,104 +1008,309 @@ func (l *Lexer) NextToken() Token {
 		return l.readString()
 	}
 
+	// Dates et heures : "#2024-01-15#", sinon TOKEN_HASH ("#list", "#12")
+	if ch == '#' && l.dateTimeAhead() {
+		return l.readDateTime()
+	}
+
 	// Opérateurs et délimiteurs
 	switch ch {
-	case '\r':
-		return l.createToken(TOKEN_EOL, "\r")
+	case '\n', '\r':
+		return l.readEOL()
 	case '+':
+		if l.peek() == '+' {
+			return l.createToken(TOKEN_INCREMENT, "++")
+		}
+		if l.peek() == '=' {
+			return l.createToken(TOKEN_PLUS_ASSIGN, "+=")
+		}
 		return l.createToken(TOKEN_PLUS, "+")
 	case '-':
+		if l.peek() == '-' {
+			return l.createToken(TOKEN_DECREMENT, "--")
+		}
+		if l.peek() == '=' {
+			return l.createToken(TOKEN_MINUS_ASSIGN, "-=")
+		}
 		if l.peek() == '>' {
-			l.consume()
 			return l.createToken(TOKEN_RARROW, "->")
 		}
 		return l.createToken(TOKEN_MINUS, "-")
 	case '*':
+		if l.peek() == '*' {
+			return l.createToken(TOKEN_POWER, "**")
+		}
+		if l.peek() == '=' {
+			return l.createToken(TOKEN_MULTIPLY_ASSIGN, "*=")
+		}
 		return l.createToken(TOKEN_MULTIPLY, "*")
 	case '/':
+		if l.peek() == '=' {
+			return l.createToken(TOKEN_DIVIDE_ASSIGN, "/=")
+		}
 		return l.createToken(TOKEN_DIVIDE, "/")
+	case '%':
+		return l.createToken(TOKEN_MODULO, "%")
 	case '=':
 		if l.peek() == '=' {
-			l.consume()
 			return l.createToken(TOKEN_EQUAL, "==")
 		}
+		if l.peek() == '>' {
+			return l.createToken(TOKEN_FAT_ARROW, "=>")
+		}
+		if l.peek() == '<' && l.lenient {
+			l.addWarning(l.line, l.column, "%q read as %q", "=<", "<=")
+			return l.createToken(TOKEN_LESS_EQUAL, "=<")
+		}
 		return l.createToken(TOKEN_ASSIGN, "=")
 	case '<':
 		if l.peek() == '=' {
-			l.consume()
+			if l.peekN(2) == '>' {
+				return l.createToken(TOKEN_SPACESHIP, "<=>")
+			}
 			return l.createToken(TOKEN_LESS_EQUAL, "<=")
 		}
 		if l.peek() == '>' {
-			l.consume()
 			return l.createToken(TOKEN_NOT_EQUAL, "<>")
 		}
-		if l.peek() == '-' {
-			l.consume()
+		if l.peek() == '-' { // Glouton : "a<-b" est une flèche (voir TOKEN_LARROW)
 			return l.createToken(TOKEN_LARROW, "<-")
 		}
+		if l.peek() == '<' {
+			return l.createToken(TOKEN_SHIFT_LEFT, "<<")
+		}
 		return l.createToken(TOKEN_LESS, "<")
 	case '>':
 		if l.peek() == '=' {
-			l.consume()
 			return l.createToken(TOKEN_GREATER_EQUAL, ">=")
 		}
+		if l.peek() == '>' {
+			return l.createToken(TOKEN_SHIFT_RIGHT, ">>")
+		}
 		return l.createToken(TOKEN_GREATER, ">")
 	case '!':
 		if l.peek() == '=' {
-			l.consume()
 			return l.createToken(TOKEN_NOT_EQUAL, "!=")
 		}
 		return l.createToken(TOKEN_NOT, "!")
-	case '[':
-		if l.peek() == '=' {
-			l.consume()
-			return l.createToken(TOKEN_LBRACKET, "[")
+	case '&':
+		if l.peek() == '&' {
+			return l.createToken(TOKEN_AND, "&&")
 		}
-	case ']':
-		if l.peek() == '=' {
-			l.consume()
-			return l.createToken(TOKEN_RBRACKET, "]")
+		return l.createToken(TOKEN_BIT_AND, "&")
+	case '|':
+		if l.peek() == '|' {
+			return l.createToken(TOKEN_OR, "||")
+		}
+		if l.peek() == '>' {
+			return l.createToken(TOKEN_PIPE, "|>")
 		}
+		return l.createToken(TOKEN_BIT_OR, "|")
+	case '^':
+		return l.createToken(TOKEN_BIT_XOR, "^")
+	case '~':
+		return l.createToken(TOKEN_BIT_NOT, "~")
+	case '?':
+		return l.createToken(TOKEN_QUESTION, "?")
+	case '#':
+		return l.createToken(TOKEN_HASH, "#")
+	case '[':
+		return l.createToken(TOKEN_LBRACKET, "[")
+	case ']':
+		return l.createToken(TOKEN_RBRACKET, "]")
 	case '(':
 		return l.createToken(TOKEN_LPAREN, "(")
 	case ')':
 		return l.createToken(TOKEN_RPAREN, ")")
+	case '{':
+		return l.createToken(TOKEN_LBRACE, "{")
+	case '}':
+		return l.createToken(TOKEN_RBRACE, "}")
 	case ';':
 		return l.createToken(TOKEN_SEMICOLON, ";")
 	case ',':
 		return l.createToken(TOKEN_COMMA, ",")
 	case '.':
+		// "..." avant "..", avant "."
+		if l.peek() == '.' {
+			if l.peekN(2) == '.' {
+				return l.createToken(TOKEN_ELLIPSIS, "...")
+			}
+			return l.createToken(TOKEN_RANGE, "..")
+		}
 		return l.createToken(TOKEN_DOT, ".")
 	case ':':
-		return l.createToken(TOKEN_DOT, ".")
+		if l.peek() == ':' {
+			return l.createToken(TOKEN_DOUBLE_COLON, "::")
+		}
+		if l.peek() == '=' {
+			return l.createToken(TOKEN_DECLARE, ":=")
+		}
+		return l.createToken(TOKEN_COLON, ":")
+	case '@':
+		return l.createToken(TOKEN_AT, "@")
+	case '\\':
+		return l.createToken(TOKEN_BACKSLASH, "\\")
+	case '`':
+		return l.createToken(TOKEN_BACKTICK, "`")
+	case '$':
+		if l.peek() == '{' {
+			return l.createToken(TOKEN_INTERP_START, "${")
+		}
+		return l.createToken(TOKEN_DOLLAR, "$")
 	}
 
 	// Token inconnu
-	token := l.createToken(TOKEN_EOF, string(ch))
-	l.consume()
-	return token
+	l.addError(l.line, l.column, "unexpected character %q", l.input[l.pos:l.pos+size])
+	return l.createToken(TOKEN_ILLEGAL, l.input[l.pos:l.pos+size])
 }
 
 func (l *Lexer) readIdentifier() Token {
-	start := l.pos
-	for l.pos < len(l.input) && (unicode.IsLetter(rune(l.input[l.pos])) ||
-		unicode.IsDigit(rune(l.input[l.pos])) || l.input[l.pos] == '_') {
+	start, line, column := l.pos, l.line, l.column
+	for l.available(1) {
+		ch, _ := l.currentRune()
+		if !isIdentRune(ch) {
+			break
+		}
+		if l.tooLong() {
+			return l.tooLongToken(line, column)
+		}
 		l.consume()
 	}
 
 	value := l.input[start:l.pos]
 	tokenType := l.lookupKeyword(value)
+	if value == "_" && l.underscoreWildcard {
+		tokenType = TOKEN_UNDERSCORE
+	}
+	if tokenType == TOKEN_NOT && l.coalesceNot {
+		if t, n := l.negatedOperator(); n > 0 {
+			l.consumeN(n)
+			tokenType, value = t, l.input[start:l.pos]
+		}
+	}
 
 	return Token{
 		Type:   tokenType,
 		Value:  value,
-		Line:   l.line,
-		Column: l.column - len(value),
+		Line:   line,
+		Column: column,
 	}
 }
 
+// readVariable lit une variable "$nom" ; Value comprend le '$'.
+func (l *Lexer) readVariable() Token {
+	start, line, column := l.pos, l.line, l.column
+	l.consume() // Skip '$'
+	for l.available(1) {
+		if ch, _ := l.currentRune(); !isIdentRune(ch) {
+			break
+		}
+		if l.tooLong() {
+			return l.tooLongToken(line, column)
+		}
+		l.consume()
+	}
+	return Token{
+		Type:   TOKEN_VARIABLE,
+		Value:  l.input[start:l.pos],
+		Line:   line,
+		Column: column,
+	}
+}
+
+// tooLong indique si le token en cours a atteint la longueur maximale fixée
+// par SetMaxTokenLength : le lecteur doit alors s'arrêter au lieu de
+// consommer un caractère de plus.
+func (l *Lexer) tooLong() bool {
+	return l.maxTokenLength > 0 && l.pos-l.start >= l.maxTokenLength
+}
+
+// tooLongToken renvoie en TOKEN_ILLEGAL les maxTokenLength premiers octets
+// d'un token trop long, lu de l.start à pos.
+func (l *Lexer) tooLongToken(line, column int) Token {
+	l.addError(line, column, "token exceeds maximum length of %d bytes", l.maxTokenLength)
+	return Token{
+		Type:   TOKEN_ILLEGAL,
+		Value:  l.input[l.start:min(l.pos, l.start+l.maxTokenLength)],
+		Line:   line,
+		Column: column,
+	}
+}
+
+// negatedOperator regarde si "in" ou "like" suit le "not" qui vient d'être
+// lu, séparé par des espaces ou tabulations. Elle renvoie le token composé
+// et le nombre d'octets à consommer, ou 0.
+func (l *Lexer) negatedOperator() (TokenType, int) {
+	n := 0
+	for l.peekN(n) == ' ' || l.peekN(n) == '\t' {
+		n++
+	}
+	word := n
+	for l.peekN(n) < utf8.RuneSelf && isIdentRune(rune(l.peekN(n))) {
+		n++
+	}
+	if word == 0 || l.peekN(n) >= utf8.RuneSelf {
+		return 0, 0
+	}
+	switch l.lookupKeyword(l.input[l.pos+word : l.pos+n]) {
+	case TOKEN_IN:
+		return TOKEN_NOT_IN, n
+	case TOKEN_LIKE:
+		return TOKEN_NOT_LIKE, n
+	}
+	return 0, 0
+}
+
+// asciiLower écrit dans dst, de même longueur que s, la forme en minuscules
+// de s. Elle renvoie false si s contient un caractère non ASCII.
+func asciiLower(dst []byte, s string) bool {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= utf8.RuneSelf {
+			return false
+		}
+		if 'A' <= ch && ch <= 'Z' {
+			ch += 'a' - 'A'
+		}
+		dst[i] = ch
+	}
+	return true
+}
+
+// isIdentRune indique si ch peut figurer dans un identifiant après le
+// premier caractère.
+func isIdentRune(ch rune) bool {
+	return unicode.IsLetter(ch) || unicode.IsDigit(ch) || ch == '_'
+}
+
+// AddKeyword déclare un mot-clé propre à l'appelant. Comme les mots-clés
+// prédéfinis, il est reconnu sans tenir compte de la casse (sauf avec
+// SetKeywordsCaseSensitive), et il est consulté avant eux : il peut donc
+// en redéfinir un. Les mots-clés ajoutés sont conservés par Reset.
+func (l *Lexer) AddKeyword(word string, t TokenType) {
+	if l.keywords == nil {
+		l.keywords = make(map[string]TokenType)
+	}
+	l.keywords[strings.ToLower(word)] = t
+}
+
 func (l *Lexer) lookupKeyword(ident string) TokenType {
-	switch strings.ToLower(ident) {
+	// Un mot ASCII court est mis en minuscules sur la pile, sans allocation
+	var lower [32]byte
+	var word string
+	if len(ident) <= len(lower) && asciiLower(lower[:len(ident)], ident) {
+		word = string(lower[:len(ident)])
+	} else {
+		word = strings.ToLower(ident)
+	}
+	if l.caseSensitive && word != ident {
+		return TOKEN_IDENTIFIER
+	}
+	if t, ok := l.keywords[word]; ok {
+		return t
+	}
+	switch word {
 	case "if":
 		return TOKEN_IF
 	case "else":
@@ -286,6 +1365,12 @@ func (l *Lexer) lookupKeyword(ident string) TokenType {
 		return TOKEN_RECURSIVE
 	case "browse":
 		return TOKEN_BROWSE
+	case "is":
+		return TOKEN_IS
+	case "as":
+		return TOKEN_AS
+	case "then":
+		return TOKEN_THEN
 	case "in":
 		return TOKEN_IN
 	case "like":
@@ -294,57 +1379,482 @@ func (l *Lexer) lookupKeyword(ident string) TokenType {
 		return TOKEN_BETWEEN
 	case "not":
 		return TOKEN_NOT
+	case "and":
+		return TOKEN_AND
+	case "or":
+		return TOKEN_OR
+	case "xor":
+		return TOKEN_XOR
+	case "mod":
+		return TOKEN_MODULO
+	case "div":
+		return TOKEN_IDIV
+	case "true", "false":
+		return TOKEN_BOOL
+	case "null", "nil":
+		return TOKEN_NULL
 	default:
 		return TOKEN_IDENTIFIER
 	}
 }
 
 func (l *Lexer) readNumber() Token {
-	start := l.pos
-	for l.pos < len(l.input) && unicode.IsDigit(rune(l.input[l.pos])) {
-		l.consume()
+	start, line, column := l.pos, l.line, l.column
+	tokenType := TOKEN_NUMBER
+	separated := false
+
+	// Préfixes "0x", "0b" et "0o" : le préfixe doit être suivi d'au moins
+	// un chiffre de la base, sinon "0x" donne NUMBER "0" puis IDENTIFIER "x".
+	isDigit := radixDigit(l.peek())
+	if l.peekN(0) == '0' && isDigit != nil && isDigit(l.peekN(2)) {
+		l.consumeN(2) // Reads the prefix
+		separated = l.readDigits(isDigit)
+	} else {
+		separated = l.readDigits(isDecimalDigit)
+
+		// Partie décimale : le point doit être suivi d'au moins un chiffre,
+		// sinon il reste un TOKEN_DOT (accès aux champs d'un record).
+		if l.available(1) && l.input[l.pos] == '.' && isDecimalDigit(l.peek()) {
+			tokenType = TOKEN_FLOAT
+			l.consume() // Reads '.'
+			separated = l.readDigits(isDecimalDigit) || separated
+		}
+
+		// Exposant : 'e' ou 'E', signe optionnel, puis au moins un chiffre,
+		// sinon "1e" donne NUMBER "1" puis IDENTIFIER "e".
+		if l.available(1) && (l.input[l.pos] == 'e' || l.input[l.pos] == 'E') {
+			n := 1
+			if sign := l.peekN(n); sign == '+' || sign == '-' {
+				n++
+			}
+			if isDecimalDigit(l.peekN(n)) {
+				tokenType = TOKEN_FLOAT
+				l.consumeN(n) // Reads 'e' and the sign
+				separated = l.readDigits(isDecimalDigit) || separated
+			}
+		}
+	}
+
+	if l.truncated {
+		l.truncated = false
+		return l.tooLongToken(line, column)
+	}
+
+	// Suffixe d'unité ("10k", "1.5M"), conservé dans Value
+	suffix := l.numberSuffix()
+	l.consumeN(utf8.RuneCountInString(suffix))
+
+	// Nombre collé à une lettre ("123abc") : tout le mot est rejeté en
+	// mode SetStrictNumbers, au lieu de donner NUMBER puis IDENTIFIER.
+	if ch, _ := l.currentRune(); l.strictNumbers && isIdentRune(ch) {
+		for l.available(1) {
+			if ch, _ := l.currentRune(); !isIdentRune(ch) {
+				break
+			}
+			l.consume()
+		}
+		value := l.input[start:l.pos]
+		l.addError(line, column, "malformed number %q", value)
+		return Token{
+			Type:   TOKEN_ILLEGAL,
+			Value:  value,
+			Line:   line,
+			Column: column,
+		}
+	}
+
+	// Pourcentage "50%" : le '%' collé au nombre n'est suivi d'aucun
+	// opérande, sinon il reste un TOKEN_MODULO ("50%3", "n%m"). Après des
+	// blancs, un signe, une parenthèse, un chiffre ou un '$' annonce aussi un
+	// opérande ("5% -3", "5% (n)") ; un mot ("50% and") n'en annonce pas.
+	if l.peekN(0) == '%' {
+		l.available(1 + utf8.UTFMax)
+		next, _ := utf8.DecodeRuneInString(l.input[l.pos+1:])
+		n := 1
+		for l.peekN(n) == ' ' || l.peekN(n) == '\t' {
+			n++
+		}
+		after := l.peekN(n)
+		operand := isDecimalDigit(after) || (after != 0 && strings.IndexByte("+-($", after) >= 0)
+		if !isIdentRune(next) && next != '"' && next != '\'' && !operand {
+			tokenType = TOKEN_PERCENT
+			l.consume() // Reads '%'
+		}
 	}
 
 	value := l.input[start:l.pos]
+	if separated {
+		var local strings.Builder
+		sb := l.valueBuilder(&local)
+		mark := sb.Len()
+		sb.Grow(len(value))
+		for i := 0; i < len(value); i++ {
+			if value[i] != '_' {
+				sb.WriteByte(value[i])
+			}
+		}
+		value = sb.String()[mark:]
+	}
 	return Token{
-		Type:   TOKEN_NUMBER,
+		Type:   tokenType,
 		Value:  value,
-		Line:   l.line,
-		Column: l.column - len(value),
+		Line:   line,
+		Column: column,
+	}
+}
+
+// numberSuffix renvoie le plus long des suffixes déclarés par
+// SetNumberSuffixes qui suit la position courante sans être lui-même suivi
+// d'un caractère d'identifiant, ou "" s'il n'y en a pas.
+func (l *Lexer) numberSuffix() string {
+	best := ""
+	for _, suffix := range l.numberSuffixes {
+		if len(suffix) <= len(best) || !l.hasPrefix(suffix) {
+			continue
+		}
+		l.available(len(suffix) + utf8.UTFMax)
+		if next, _ := utf8.DecodeRuneInString(l.input[l.pos+len(suffix):]); !isIdentRune(next) {
+			best = suffix
+		}
+	}
+	return best
+}
+
+// readDigits consomme une suite de chiffres acceptés par isDigit, où un
+// '_' isolé peut séparer deux chiffres ("1_000"). Elle indique si un
+// séparateur a été rencontré.
+func (l *Lexer) readDigits(isDigit func(byte) bool) bool {
+	separated := false
+	for l.available(1) {
+		separator := l.input[l.pos] == '_' && l.pos > 0 && isDigit(l.input[l.pos-1]) &&
+			isDigit(l.peek())
+		if !separator && !isDigit(l.input[l.pos]) {
+			break
+		}
+		if l.tooLong() {
+			l.truncated = true
+			break
+		}
+		separated = separated || separator
+		l.consume()
 	}
+	return separated
 }
 
 func (l *Lexer) readString() Token {
+	line, column := l.line, l.column
+	quote := l.peekN(0)
 	l.consume() // Skip opening quote
 	start := l.pos
 
-	for l.pos < len(l.input) && l.input[l.pos] != '"' {
-		if l.input[l.pos] == '\n' {
-			l.line++
-			l.column = 1
+	// Le texte n'est recopié dans sb qu'à partir du premier échappement ;
+	// sans échappement, la valeur reste une simple sous-chaîne de l'entrée.
+	var local strings.Builder
+	sb := l.valueBuilder(&local)
+	mark := sb.Len()
+	escaped := false
+	over := false
+	for l.available(1) && l.input[l.pos] != quote {
+		// Chaîne trop longue : la suite est parcourue sans être recopiée
+		over = over || l.tooLong()
+		ch := l.input[l.pos]
+		if over {
+			if ch == '\\' {
+				l.consume() // Skip '\', so that \" does not close the string
+			}
+			l.consume()
+			continue
+		}
+		if ch == '\\' && l.available(2) {
+			if !escaped {
+				sb.WriteString(l.input[start:l.pos])
+				escaped = true
+			}
+			l.consume() // Skip '\'
+			ch = l.input[l.pos]
+			switch ch {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case '\\', '"', '\'':
+				sb.WriteByte(ch)
+			case 'u':
+				if r, n, ok := l.unicodeEscape(); ok {
+					sb.WriteRune(r)
+					l.consumeN(n) // Reads the code point, 'u' excepted
+				} else {
+					l.addError(l.line, l.column-1, "invalid unicode escape")
+					sb.WriteString(`\u`)
+				}
+			default:
+				// Échappement inconnu : conservé tel quel
+				_, size := l.currentRune()
+				sb.WriteByte('\\')
+				sb.WriteString(l.input[l.pos : l.pos+size])
+			}
+		} else if escaped {
+			_, size := l.currentRune()
+			sb.WriteString(l.input[l.pos : l.pos+size])
 		}
 		l.consume()
 	}
 
 	value := l.input[start:l.pos]
-	l.consume() // Skip closing quote
+	if escaped {
+		value = sb.String()[mark:]
+	}
+
+	// Chaîne non terminée : le texte partiel est renvoyé en TOKEN_ILLEGAL
+	tokenType := TOKEN_ILLEGAL
+	if l.available(1) {
+		tokenType = TOKEN_STRING
+		l.consume() // Skip closing quote
+	} else {
+		l.addError(line, column, "unterminated string")
+	}
+	if over {
+		return l.tooLongToken(line, column)
+	}
 
 	return Token{
-		Type:   TOKEN_STRING,
+		Type:   tokenType,
 		Value:  value,
-		Line:   l.line,
-		Column: l.column - len(value) - 2,
+		Line:   line,
+		Column: column,
+		Quote:  quote,
 	}
 }
 
+// unicodeEscape décode un échappement "\uXXXX" ou "\u{X...}" (1 à 6
+// chiffres) dont le 'u' est à la position courante. Elle renvoie le
+// caractère et le nombre d'octets qui suivent le 'u', ou false si
+// l'échappement est mal formé ou ne désigne pas un caractère valide.
+func (l *Lexer) unicodeEscape() (rune, int, bool) {
+	var digits string
+	n := 4
+	if l.peekN(1) == '{' {
+		n = 2
+		for isHexDigit(l.peekN(n)) {
+			n++
+		}
+		if n == 2 || n > 8 || l.peekN(n) != '}' {
+			return 0, 0, false
+		}
+		digits = l.input[l.pos+2 : l.pos+n]
+	} else {
+		for i := 1; i <= 4; i++ {
+			if !isHexDigit(l.peekN(i)) {
+				return 0, 0, false
+			}
+		}
+		digits = l.input[l.pos+1 : l.pos+5]
+	}
+	v, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || !utf8.ValidRune(rune(v)) {
+		return 0, 0, false
+	}
+	return rune(v), n, true
+}
+
+// readRawString lit une chaîne brute r"..." ou r'...' : le contenu est
+// recopié tel quel, '\' compris, jusqu'au délimiteur fermant.
+func (l *Lexer) readRawString() Token {
+	line, column := l.line, l.column
+	l.consume() // Skip 'r'
+	quote := l.peekN(0)
+	l.consume() // Skip opening quote
+	start := l.pos
+	over := false
+	for l.available(1) && l.input[l.pos] != quote {
+		over = over || l.tooLong()
+		l.consume()
+	}
+	value := l.input[start:l.pos]
+
+	tokenType := TOKEN_ILLEGAL
+	if l.available(1) {
+		tokenType = TOKEN_STRING
+		l.consume() // Skip closing quote
+	} else {
+		l.addError(line, column, "unterminated string")
+	}
+	if over {
+		return l.tooLongToken(line, column)
+	}
+
+	return Token{
+		Type:   tokenType,
+		Value:  value,
+		Line:   line,
+		Column: column,
+		Quote:  quote,
+		Raw:    true,
+	}
+}
+
+// dateTimeAhead indique si le '#' courant ouvre un littéral de date ou
+// d'heure : un contenu de la forme AAAA-MM-JJ, HH:MM ou HH:MM:SS suivi du
+// '#' fermant. Rien n'est consommé.
+func (l *Lexer) dateTimeAhead() bool {
+	n := 1
+	for n <= len("0000-00-00") && (isDecimalDigit(l.peekN(n)) || l.peekN(n) == '-' || l.peekN(n) == ':') {
+		n++
+	}
+	return l.peekN(n) == '#' && isDateTimeShape(l.input[l.pos+1:l.pos+n])
+}
+
+// readDateTime lit un littéral "#AAAA-MM-JJ#" (TOKEN_DATE) ou "#HH:MM#",
+// "#HH:MM:SS#" (TOKEN_TIME) reconnu par dateTimeAhead et renvoie son contenu
+// sans les délimiteurs. Un mois, un jour ou une heure hors limites
+// ("#2024-13-01#") donne TOKEN_ILLEGAL.
+func (l *Lexer) readDateTime() Token {
+	line, column := l.line, l.column
+	l.consume() // Skip opening '#'
+	start := l.pos
+	for l.available(1) && l.input[l.pos] != '#' {
+		l.consume()
+	}
+	value := l.input[start:l.pos]
+	l.consume() // Skip closing '#'
+	if l.maxTokenLength > 0 && l.pos-l.start > l.maxTokenLength {
+		return l.tooLongToken(line, column)
+	}
+
+	tokenType := TOKEN_ILLEGAL
+	if isDate(value) {
+		tokenType = TOKEN_DATE
+	} else if isTime(value) {
+		tokenType = TOKEN_TIME
+	} else {
+		l.addError(line, column, "malformed date or time literal %q", value)
+	}
+
+	return Token{
+		Type:   tokenType,
+		Value:  value,
+		Line:   line,
+		Column: column,
+	}
+}
+
+// isDateTimeShape vérifie que s a la forme AAAA-MM-JJ, HH:MM ou HH:MM:SS,
+// sans contrôler les valeurs.
+func isDateTimeShape(s string) bool {
+	var layout string
+	switch len(s) {
+	case 10:
+		layout = "0000-00-00"
+	case 5:
+		layout = "00:00"
+	case 8:
+		layout = "00:00:00"
+	default:
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if layout[i] == '0' && !isDecimalDigit(s[i]) || layout[i] != '0' && s[i] != layout[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isDate vérifie que s a la forme AAAA-MM-JJ avec un mois et un jour
+// plausibles.
+func isDate(s string) bool {
+	if len(s) != 10 || s[4] != '-' || s[7] != '-' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if i != 4 && i != 7 && !isDecimalDigit(s[i]) {
+			return false
+		}
+	}
+	month := int(s[5]-'0')*10 + int(s[6]-'0')
+	day := int(s[8]-'0')*10 + int(s[9]-'0')
+	return month >= 1 && month <= 12 && day >= 1 && day <= 31
+}
+
+// isTime vérifie que s a la forme HH:MM ou HH:MM:SS avec des composantes
+// dans les bornes (heure <= 23, minutes et secondes <= 59).
+func isTime(s string) bool {
+	if len(s) != 5 && len(s) != 8 {
+		return false
+	}
+	for i := 0; i < len(s); i += 3 {
+		if !isDecimalDigit(s[i]) || !isDecimalDigit(s[i+1]) {
+			return false
+		}
+		if i+2 < len(s) && s[i+2] != ':' {
+			return false
+		}
+		n := int(s[i]-'0')*10 + int(s[i+1]-'0')
+		if (i == 0 && n > 23) || n > 59 {
+			return false
+		}
+	}
+	return true
+}
+
+// readWhitespace lit une suite d'espaces et de tabulations en mode
+// SetEmitWhitespace.
+func (l *Lexer) readWhitespace() Token {
+	line := l.line
+	column := l.column
+	start := l.pos
+	for l.available(1) && (l.input[l.pos] == ' ' || l.input[l.pos] == '\t') {
+		l.consume()
+	}
+	return Token{
+		Type:   TOKEN_WHITESPACE,
+		Value:  l.input[start:l.pos],
+		Line:   line,
+		Column: column,
+	}
+}
+
+// readEOL lit une fin de ligne "\n", "\r\n" ou "\r" en mode SetEmitEOL.
+func (l *Lexer) readEOL() Token {
+	return l.createToken(TOKEN_EOL, l.input[l.pos:l.pos+l.newlineLen()])
+}
+
+// newlineLen renvoie la longueur de la fin de ligne à la position courante :
+// 2 pour "\r\n", 1 pour "\n" ou un "\r" isolé, 0 sinon.
+func (l *Lexer) newlineLen() int {
+	if !l.available(1) {
+		return 0
+	}
+	switch l.input[l.pos] {
+	case '\n':
+		return 1
+	case '\r':
+		if l.peek() == '\n' {
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+// skipWhitespace ignore les blancs, fins de ligne comprises. En mode
+// SetEmitEOL, les fins de ligne sont laissées à readEOL ; en mode
+// SetEmitWhitespace, rien n'est ignoré.
 func (l *Lexer) skipWhitespace() {
-	for l.pos < len(l.input) {
+	for l.available(1) {
+		if l.checkIndent && (l.pos == 0 || l.input[l.pos-1] == '\n' || l.input[l.pos-1] == '\r') {
+			l.checkIndentation()
+		}
+		if l.emitWhitespace {
+			break
+		}
 		ch := l.input[l.pos]
-		if ch == ' ' || ch == '\t' || ch == '\r' {
-			l.consume()
-		} else if ch == '\n' {
-			l.line++
-			l.column = 1
+		if l.emitEOL && l.newlineLen() > 0 {
+			break
+		}
+		if ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n' {
 			l.consume()
 		} else {
 			break
@@ -352,29 +1862,98 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-func (l *Lexer) skipComment() {
-	if l.pos >= len(l.input) {
-		return
+// readComment lit un commentaire de bloc ou de fin de ligne et le renvoie en
+// TOKEN_COMMENT, délimiteurs compris, ou en TOKEN_ILLEGAL s'il n'est pas
+// fermé ou dépasse la longueur fixée par SetMaxTokenLength.
+func (l *Lexer) readComment(block bool) Token {
+	start, line, column := l.pos, l.line, l.column
+	closed := true
+	if block {
+		closed = l.skipComment()
+	} else {
+		l.skipLineComment()
 	}
-	if l.input[l.pos] != '(' || l.peek() != '*' {
-		return
+	if l.maxTokenLength > 0 && l.pos-start > l.maxTokenLength {
+		return l.tooLongToken(line, column)
 	}
-	l.consume() //Reads '*'
-	l.consume() //Move the cursor to the next position
-	for ch := l.input[l.pos]; l.pos < len(l.input) &&
-		ch != '*' && l.peek() != ')'; ch = l.input[l.pos] {
-		if ch == '\n' {
-			l.line++
-			l.column = 1
+	tokenType := TOKEN_COMMENT
+	if !closed {
+		tokenType = TOKEN_ILLEGAL
+	}
+	return Token{
+		Type:   tokenType,
+		Value:  l.input[start:l.pos],
+		Line:   line,
+		Column: column,
+	}
+}
+
+// checkIndentation examine, sans la consommer, l'indentation de la ligne qui
+// commence à la position courante. Les lignes vides ne sont pas signalées.
+func (l *Lexer) checkIndentation() {
+	tabs, spaces := false, false
+	n := 0
+	for l.available(n+1) && (l.input[l.pos+n] == ' ' || l.input[l.pos+n] == '\t') {
+		if l.input[l.pos+n] == '\t' {
+			tabs = true
+		} else {
+			spaces = true
+		}
+		n++
+	}
+	if tabs && spaces && l.available(n+1) &&
+		l.input[l.pos+n] != '\n' && l.input[l.pos+n] != '\r' {
+		l.addWarning(l.line, 1, "indentation mixes tabs and spaces")
+	}
+}
+
+// skipComment ignore un commentaire de bloc, "(* ... *)" par défaut (voir
+// SetBlockComment). Les commentaires peuvent être imbriqués :
+// "(* a (* b *) c *)" est ignoré en entier, sauf si les deux délimiteurs
+// sont identiques. Elle renvoie false si le commentaire n'est pas fermé.
+func (l *Lexer) skipComment() bool {
+	if !l.hasPrefix(l.blockOpen) {
+		return false
+	}
+	line, column := l.line, l.column
+	nested := l.blockOpen != l.blockClose
+	l.consumeN(utf8.RuneCountInString(l.blockOpen))
+	depth := 1
+	for l.available(1) {
+		if nested && l.hasPrefix(l.blockOpen) {
+			l.consumeN(utf8.RuneCountInString(l.blockOpen))
+			depth++
+			continue
+		}
+		if l.hasPrefix(l.blockClose) {
+			l.consumeN(utf8.RuneCountInString(l.blockClose))
+			depth--
+			if depth == 0 {
+				return true
+			}
+			continue
 		}
 		l.consume()
 	}
-	if l.peek() == ')' {
-		l.consume() //Reads ')'
-		l.consume() //Move the cursor to the next position
+	l.addError(line, column, "unterminated comment")
+	return false
+}
+
+// skipLineComment ignore un commentaire de fin de ligne, "//" par défaut
+// (voir SetLineComment), jusqu'à la fin de la ligne,
+// sans consommer la fin de ligne.
+func (l *Lexer) skipLineComment() {
+	for l.available(1) && l.newlineLen() == 0 {
+		l.consume()
 	}
 }
 
+// hasPrefix indique si l'entrée restante commence par s (jamais vrai pour
+// une chaîne vide).
+func (l *Lexer) hasPrefix(s string) bool {
+	return s != "" && l.available(len(s)) && strings.HasPrefix(l.input[l.pos:], s)
+}
+
 func (l *Lexer) createToken(tokenType TokenType, value string) Token {
 	token := Token{
 		Type:   tokenType,
@@ -382,26 +1961,138 @@ func (l *Lexer) createToken(tokenType TokenType, value string) Token {
 		Line:   l.line,
 		Column: l.column,
 	}
-	l.consumeN(len(value))
+	l.consumeN(utf8.RuneCountInString(value))
 	return token
 }
 
+// currentRune décode le caractère à la position courante et renvoie sa
+// taille en octets, ou (0, 0) en fin d'entrée.
+func (l *Lexer) currentRune() (rune, int) {
+	if !l.available(1) {
+		return 0, 0
+	}
+	if ch := l.input[l.pos]; ch < utf8.RuneSelf {
+		return rune(ch), 1
+	}
+	l.available(utf8.UTFMax) // Un caractère peut chevaucher deux blocs lus
+	return utf8.DecodeRuneInString(l.input[l.pos:])
+}
+
+// available indique si au moins n octets restent à lire depuis pos, en
+// complétant l'entrée depuis le flux si nécessaire.
+func (l *Lexer) available(n int) bool {
+	for l.pos+n > len(l.input) {
+		if l.src != nil && l.src.buf.Len() > len(l.input) {
+			// Un clone a déjà lu la suite du flux partagé.
+			l.input = l.src.buf.String()
+			continue
+		}
+		if l.src == nil || !l.src.fill() {
+			if l.src != nil && l.src.err != io.EOF && !l.readErrorReported() {
+				l.addError(l.line, l.column, "read error: %v", l.src.err)
+				l.errors[len(l.errors)-1].Err = l.src.err
+			}
+			return false
+		}
+		l.input = l.src.buf.String()
+	}
+	return true
+}
+
+// readErrorReported indique si l'erreur de lecture du flux figure déjà dans
+// les erreurs du lexer. Elle est recherchée plutôt que mémorisée pour qu'un
+// Restore qui l'efface la fasse signaler de nouveau.
+func (l *Lexer) readErrorReported() bool {
+	for _, e := range l.errors {
+		if e.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// fill lit un bloc supplémentaire depuis le flux. Elle renvoie false une
+// fois le flux épuisé.
+func (s *readerSource) fill() bool {
+	if s.err != nil {
+		return false
+	}
+	n, err := s.r.Read(s.chunk)
+	s.buf.Write(s.chunk[:n])
+	if err != nil {
+		s.err = err
+	}
+	return n > 0 || err == nil
+}
+
+// consume avance d'un caractère. Un '\n', ou un '\r' qui n'est pas suivi
+// d'un '\n', fait passer à la ligne suivante, en colonne 1 : "\r\n",
+// "\n" et "\r" comptent chacun pour une ligne. C'est le seul endroit où
+// line et column évoluent.
 func (l *Lexer) consume() {
-	if l.pos < len(l.input) {
-		l.pos++
-		l.column++
+	if l.available(1) {
+		ch, size := l.currentRune()
+		if ch == '\r' && l.peek() != '\n' {
+			ch = '\n'
+		}
+		l.pos += size
+		if ch == '\n' {
+			l.line++
+			l.column = 1
+		} else if ch == '\t' && l.tabWidth > 1 {
+			l.column += l.tabWidth - (l.column-1)%l.tabWidth
+		} else {
+			l.column++
+		}
 	}
 }
 
 func (l *Lexer) consumeN(n int) {
-	for i := 0; i < n && l.pos < len(l.input); i++ {
+	for i := 0; i < n && l.available(1); i++ {
 		l.consume()
 	}
 }
 
+// radixDigit renvoie le prédicat des chiffres admis après le préfixe de
+// base "0<prefix>", ou nil si prefix n'en désigne aucune.
+func radixDigit(prefix byte) func(byte) bool {
+	switch prefix {
+	case 'x', 'X':
+		return isHexDigit
+	case 'b', 'B':
+		return isBinaryDigit
+	case 'o', 'O':
+		return isOctalDigit
+	}
+	return nil
+}
+
+func isDecimalDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
+}
+
+func isBinaryDigit(ch byte) bool {
+	return ch == '0' || ch == '1'
+}
+
+func isOctalDigit(ch byte) bool {
+	return '0' <= ch && ch <= '7'
+}
+
+func isHexDigit(ch byte) bool {
+	return ('0' <= ch && ch <= '9') || ('a' <= ch && ch <= 'f') || ('A' <= ch && ch <= 'F')
+}
+
 func (l *Lexer) peek() byte {
-	if l.pos+1 < len(l.input) {
-		return l.input[l.pos+1]
+	return l.peekN(1)
+}
+
+// peekN renvoie l'octet situé n positions après la position courante, ou 0
+// au-delà de la fin de l'entrée. peekN(0) lit l'octet courant sans risque
+// de dépassement, même si pos a atteint la fin.
+func (l *Lexer) peekN(n int) byte {
+	if l.available(n + 1) {
+		return l.input[l.pos+n]
 	}
 	return 0
 }
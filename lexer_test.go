@@ -0,0 +1,449 @@
+package lexer
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// readErrors renvoie les erreurs de l dont Err est renseigné.
+func readErrors(l *Lexer) []LexError {
+	var errs []LexError
+	for _, e := range l.Errors() {
+		if e.Err != nil {
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+func TestCloneReportsReadErrorOnce(t *testing.T) {
+	errBroken := errors.New("broken pipe")
+	l := NewLexerReader(io.MultiReader(strings.NewReader("a b"), iotest.ErrReader(errBroken)))
+	c := l.Clone()
+
+	// Le clone atteint la fin du flux en premier, puis l'original.
+	c.Tokenize()
+	l.Tokenize()
+	for name, lx := range map[string]*Lexer{"clone": c, "original": l} {
+		errs := readErrors(lx)
+		if len(errs) != 1 || !errors.Is(errs[0], errBroken) {
+			t.Errorf("%s: read errors = %v, want one wrapping %v", name, errs, errBroken)
+		}
+	}
+}
+
+func TestRestoreReportsReadErrorAgain(t *testing.T) {
+	errBroken := errors.New("broken pipe")
+	l := NewLexerReader(io.MultiReader(strings.NewReader("a b"), iotest.ErrReader(errBroken)))
+	s := l.Snapshot()
+	l.Tokenize()
+	l.Restore(s)
+	if errs := readErrors(l); len(errs) != 0 {
+		t.Fatalf("after Restore: read errors = %v, want none", errs)
+	}
+	l.Tokenize()
+	if errs := readErrors(l); len(errs) != 1 {
+		t.Errorf("read errors = %v, want one", errs)
+	}
+}
+
+func TestBlockCommentDelimiters(t *testing.T) {
+	tests := []struct {
+		open, close string
+		src         string
+		want        []string
+	}{
+		{"(*", "*)", "(* a (* b *) c *) x", []string{"x"}},
+		{"'''", "'''", "'''doc''' x '''more''' y", []string{"x", "y"}},
+		{"'", "'", "a ' note ' b", []string{"a", "b"}},
+		{`"""`, `"""`, `"""doc""" x`, []string{"x"}},
+		{"/*", "", "a /* b", []string{"a", "/", "*", "b"}},
+	}
+	for _, tt := range tests {
+		l := NewLexer(tt.src)
+		l.SetBlockComment(tt.open, tt.close)
+		var got []string
+		for _, tok := range l.Tokenize() {
+			if tok.Type != TOKEN_EOF {
+				got = append(got, tok.Value)
+			}
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") || len(l.Errors()) != 0 {
+			t.Errorf("SetBlockComment(%q, %q) on %q: got %q, errors %v; want %q",
+				tt.open, tt.close, tt.src, got, l.Errors(), tt.want)
+		}
+	}
+}
+
+func TestPositionAfterMultilineComment(t *testing.T) {
+	for _, nl := range []string{"\n", "\r\n", "\r"} {
+		src := "(* one" + nl + "two" + nl + "  three *) x"
+		tok := NewLexer(src).NextToken()
+		if tok.Type != TOKEN_IDENTIFIER || tok.Line != 3 || tok.Column != 12 {
+			t.Errorf("%q: got %v %q at %d:%d, want IDENTIFIER \"x\" at 3:12",
+				src, tok.Type, tok.Value, tok.Line, tok.Column)
+		}
+	}
+}
+
+func TestTruncatedInputDoesNotPanic(t *testing.T) {
+	inputs := []string{
+		"(", "\"", "'", "(*", "(* a", "(* (*", "//", "r", `r"`, `"\`, `"\u`, `"\u{`,
+		"#", "#2024", "#2024-01-01", "#12:", "0x", "0b", "1e", "1e+", "1.", "1_",
+		"50%", "$", "${", "not", "not ", "<", "<=", ":", ".", "..", "é", "\xff",
+	}
+	options := map[string]func(*Lexer){
+		"default":     func(*Lexer) {},
+		"comments":    func(l *Lexer) { l.SetEmitComments(true) },
+		"whitespace":  func(l *Lexer) { l.SetEmitWhitespace(true) },
+		"dollar":      func(l *Lexer) { l.SetDollarVariables(true) },
+		"negated":     func(l *Lexer) { l.SetNegatedOperators(true) },
+		"strict":      func(l *Lexer) { l.SetStrictNumbers(true) },
+		"maxLength":   func(l *Lexer) { l.SetMaxTokenLength(1) },
+		"indentation": func(l *Lexer) { l.SetCheckIndentation(true) },
+	}
+	for _, src := range inputs {
+		for name, set := range options {
+			for _, l := range []*Lexer{NewLexer(src), NewLexerReader(iotest.OneByteReader(strings.NewReader(src)))} {
+				set(l)
+				tokens := l.Tokenize()
+				if last := tokens[len(tokens)-1]; last.Type != TOKEN_EOF || last.EndOffset != len(src) {
+					t.Errorf("%s %q: last token %v ends at %d, want EOF at %d",
+						name, src, last.Type, last.EndOffset, len(src))
+				}
+			}
+		}
+	}
+}
+
+func TestComparisonSpellings(t *testing.T) {
+	tests := []struct {
+		src  string
+		want TokenType
+	}{
+		{"<>", TOKEN_NOT_EQUAL},
+		{"!=", TOKEN_NOT_EQUAL},
+		{"==", TOKEN_EQUAL},
+		{"=", TOKEN_ASSIGN},
+		{"<=", TOKEN_LESS_EQUAL},
+		{">=", TOKEN_GREATER_EQUAL},
+	}
+	for _, tt := range tests {
+		tokens := NewLexer(tt.src).Tokenize()
+		if len(tokens) != 2 || tokens[0].Type != tt.want || tokens[0].Value != tt.src {
+			t.Errorf("%q: got %+v, want one %v with Value %q", tt.src, tokens, tt.want, tt.src)
+		}
+	}
+}
+
+func TestLoneBracketConsumesOneByte(t *testing.T) {
+	l := NewLexer("[")
+	tok := l.NextToken()
+	if tok.Type != TOKEN_LBRACKET || tok.Value != "[" || tok.StartOffset != 0 || tok.EndOffset != 1 {
+		t.Fatalf("got %+v, want LBRACKET covering [0:1]", tok)
+	}
+	if _, _, offset := l.Position(); offset != 1 {
+		t.Errorf("offset after '[' = %d, want 1", offset)
+	}
+	if tok := l.NextToken(); tok.Type != TOKEN_EOF {
+		t.Errorf("next token = %v, want EOF", tok.Type)
+	}
+}
+
+func TestHashOrDateTime(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []TokenType
+	}{
+		{"#2024-01-15#", []TokenType{TOKEN_DATE}},
+		{"#12:30#", []TokenType{TOKEN_TIME}},
+		{"#12:30:15#", []TokenType{TOKEN_TIME}},
+		{"#2024-13-01#", []TokenType{TOKEN_ILLEGAL}},
+		{"#list", []TokenType{TOKEN_HASH, TOKEN_IDENTIFIER}},
+		{"#12", []TokenType{TOKEN_HASH, TOKEN_NUMBER}},
+		{"#1 + 2", []TokenType{TOKEN_HASH, TOKEN_NUMBER, TOKEN_PLUS, TOKEN_NUMBER}},
+		{"#2024-01-15", []TokenType{TOKEN_HASH, TOKEN_NUMBER, TOKEN_MINUS, TOKEN_NUMBER, TOKEN_MINUS, TOKEN_NUMBER}},
+		{"#12#", []TokenType{TOKEN_HASH, TOKEN_NUMBER, TOKEN_HASH}},
+	}
+	for _, tt := range tests {
+		if got := tokenTypes(tt.src); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+// tokenTypes renvoie les types des tokens de src, sans le TOKEN_EOF final.
+func tokenTypes(src string) []TokenType {
+	var types []TokenType
+	for _, tok := range NewLexer(src).Tokenize() {
+		if tok.Type != TOKEN_EOF {
+			types = append(types, tok.Type)
+		}
+	}
+	return types
+}
+
+// benchmarkInput mêle mots-clés en majuscules, chaînes avec échappements et
+// nombres avec séparateurs, dont les Value ne sont pas des sous-chaînes.
+var benchmarkInput = strings.Repeat("SELECT name, total FROM orders WHERE total >= 1_000 (* big *)\n"+
+	"let msg := \"line\\none\" + 'it\\'s' // note\nif x <> 0.5e3 then y = #2024-01-15# end\n", 100)
+
+func BenchmarkNextToken(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkInput)))
+	l := NewLexer(benchmarkInput)
+	for b.Loop() {
+		l.Reset(benchmarkInput)
+		for l.NextToken().Type != TOKEN_EOF {
+		}
+	}
+}
+
+func BenchmarkNextTokenInto(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkInput)))
+	l := NewLexer(benchmarkInput)
+	var tok Token
+	for b.Loop() {
+		l.Reset(benchmarkInput)
+		for l.NextTokenInto(&tok) {
+		}
+	}
+}
+
+func TestNextTokenIntoMatchesNextToken(t *testing.T) {
+	// Assez de Value construites pour remplir plusieurs blocs partagés
+	src := strings.Repeat(benchmarkInput, 4)
+	want := NewLexer(src).Tokenize()
+	var got []Token
+	l := NewLexer(src)
+	var tok Token
+	for l.NextTokenInto(&tok) {
+		got = append(got, tok)
+	}
+	got = append(got, tok)
+	if len(got) != len(want) {
+		t.Fatalf("got %d tokens, want %d", len(got), len(want))
+	}
+	// Les Value construites dans les blocs partagés restent intactes
+	// jusqu'à la fin, blocs suivants compris.
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("token %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestOperators(t *testing.T) {
+	tests := []struct {
+		op   string
+		want TokenType
+	}{
+		{"+", TOKEN_PLUS}, {"-", TOKEN_MINUS}, {"*", TOKEN_MULTIPLY}, {"/", TOKEN_DIVIDE},
+		{"%", TOKEN_MODULO}, {"**", TOKEN_POWER},
+		{"++", TOKEN_INCREMENT}, {"--", TOKEN_DECREMENT},
+		{"+=", TOKEN_PLUS_ASSIGN}, {"-=", TOKEN_MINUS_ASSIGN},
+		{"*=", TOKEN_MULTIPLY_ASSIGN}, {"/=", TOKEN_DIVIDE_ASSIGN},
+		{"=", TOKEN_ASSIGN}, {":=", TOKEN_DECLARE}, {"==", TOKEN_EQUAL},
+		{"<>", TOKEN_NOT_EQUAL}, {"!=", TOKEN_NOT_EQUAL},
+		{"<", TOKEN_LESS}, {"<=", TOKEN_LESS_EQUAL}, {">", TOKEN_GREATER}, {">=", TOKEN_GREATER_EQUAL},
+		{"<=>", TOKEN_SPACESHIP}, {"!", TOKEN_NOT}, {"&&", TOKEN_AND}, {"||", TOKEN_OR},
+		{"&", TOKEN_BIT_AND}, {"|", TOKEN_BIT_OR}, {"^", TOKEN_BIT_XOR}, {"~", TOKEN_BIT_NOT},
+		{"<<", TOKEN_SHIFT_LEFT}, {">>", TOKEN_SHIFT_RIGHT},
+		{"->", TOKEN_RARROW}, {"<-", TOKEN_LARROW}, {"=>", TOKEN_FAT_ARROW}, {"|>", TOKEN_PIPE},
+		{"?", TOKEN_QUESTION}, {"..", TOKEN_RANGE}, {"...", TOKEN_ELLIPSIS}, {"::", TOKEN_DOUBLE_COLON},
+	}
+	for _, tt := range tests {
+		src := "a " + tt.op + " b"
+		tokens := NewLexer(src).Tokenize()
+		if len(tokens) != 4 {
+			t.Errorf("%q: got %d tokens %+v, want 4", src, len(tokens), tokens)
+			continue
+		}
+		if op := tokens[1]; op.Type != tt.want || op.Value != tt.op || op.Column != 3 {
+			t.Errorf("%q: got %v %q at column %d, want %v %q at column 3",
+				src, op.Type, op.Value, op.Column, tt.want, tt.op)
+		}
+		if b := tokens[2]; b.Type != TOKEN_IDENTIFIER || b.Column != 4+len(tt.op) {
+			t.Errorf("%q: operand after the operator is %v at column %d, want IDENTIFIER at column %d",
+				src, b.Type, b.Column, 4+len(tt.op))
+		}
+	}
+}
+
+func TestEmptyString(t *testing.T) {
+	for _, src := range []string{`x = ""`, `x = ''`} {
+		tokens := NewLexer(src).Tokenize()
+		if len(tokens) != 4 {
+			t.Fatalf("%q: got %+v, want x, =, string, EOF", src, tokens)
+		}
+		if s := tokens[2]; s.Type != TOKEN_STRING || s.Value != "" || s.Column != 5 || s.Quote != src[4] {
+			t.Errorf("%q: got %+v, want an empty STRING at column 5", src, s)
+		}
+	}
+}
+
+func TestMaxTokenLengthResumesAfterDelimiter(t *testing.T) {
+	tests := []struct {
+		src, value string
+	}{
+		{`"abcdefgh" x`, `"abc`},
+		{`"ab\"cdefgh" x`, `"ab\`},
+		{`"abcd\"efgh" x`, `"abc`},
+		{`r"abcdefgh" x`, `r"ab`},
+		{`#2024-01-15# x`, `#202`},
+		{`(* abcdefgh *) x`, `(* a`},
+		{"// abcdefgh\nx", "// a"},
+	}
+	for _, tt := range tests {
+		l := NewLexer(tt.src)
+		l.SetMaxTokenLength(4)
+		l.SetEmitComments(true)
+		tokens := l.Tokenize()
+		if len(tokens) != 3 || tokens[0].Type != TOKEN_ILLEGAL || tokens[0].Value != tt.value ||
+			tokens[1].Type != TOKEN_IDENTIFIER || tokens[1].Value != "x" || len(l.Errors()) != 1 {
+			t.Errorf("%q: got %+v, errors %v; want ILLEGAL %q, IDENTIFIER \"x\" and one error",
+				tt.src, tokens, l.Errors(), tt.value)
+		}
+	}
+
+	// Un commentaire ignoré est signalé de la même façon.
+	l := NewLexer("(* abcdefgh *) x")
+	l.SetMaxTokenLength(4)
+	if tokens := l.Tokenize(); len(tokens) != 2 || tokens[0].Value != "x" || len(l.Errors()) != 1 {
+		t.Errorf("skipped comment: got %+v, errors %v", tokens, l.Errors())
+	}
+}
+
+func TestPercentOrModulo(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []TokenType
+	}{
+		{"50%", []TokenType{TOKEN_PERCENT}},
+		{"50% and x", []TokenType{TOKEN_PERCENT, TOKEN_AND, TOKEN_IDENTIFIER}},
+		{"(50%)", []TokenType{TOKEN_LPAREN, TOKEN_PERCENT, TOKEN_RPAREN}},
+		{"50%, 3", []TokenType{TOKEN_PERCENT, TOKEN_COMMA, TOKEN_NUMBER}},
+		{"5%3", []TokenType{TOKEN_NUMBER, TOKEN_MODULO, TOKEN_NUMBER}},
+		{"5%n", []TokenType{TOKEN_NUMBER, TOKEN_MODULO, TOKEN_IDENTIFIER}},
+		{"5%-3", []TokenType{TOKEN_NUMBER, TOKEN_MODULO, TOKEN_MINUS, TOKEN_NUMBER}},
+		{"5% -3", []TokenType{TOKEN_NUMBER, TOKEN_MODULO, TOKEN_MINUS, TOKEN_NUMBER}},
+		{"5% +3", []TokenType{TOKEN_NUMBER, TOKEN_MODULO, TOKEN_PLUS, TOKEN_NUMBER}},
+		{"5% (n)", []TokenType{TOKEN_NUMBER, TOKEN_MODULO, TOKEN_LPAREN, TOKEN_IDENTIFIER, TOKEN_RPAREN}},
+		{"5% 3", []TokenType{TOKEN_NUMBER, TOKEN_MODULO, TOKEN_NUMBER}},
+		{"5%\t$", []TokenType{TOKEN_NUMBER, TOKEN_MODULO, TOKEN_DOLLAR}},
+		{"% 3", []TokenType{TOKEN_MODULO, TOKEN_NUMBER}},
+	}
+	for _, tt := range tests {
+		if got := tokenTypes(tt.src); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestLessMinusIsGreedy(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []TokenType
+	}{
+		{"a<-b", []TokenType{TOKEN_IDENTIFIER, TOKEN_LARROW, TOKEN_IDENTIFIER}},
+		{"a < -b", []TokenType{TOKEN_IDENTIFIER, TOKEN_LESS, TOKEN_MINUS, TOKEN_IDENTIFIER}},
+		{"a< -b", []TokenType{TOKEN_IDENTIFIER, TOKEN_LESS, TOKEN_MINUS, TOKEN_IDENTIFIER}},
+	}
+	for _, tt := range tests {
+		if got := tokenTypes(tt.src); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	l := NewLexer(`"abc`)
+	l.Tokenize()
+	s := l.Snapshot()
+	l.Reset(`"abc`)
+	l.Restore(s) // Moins d'erreurs qu'au moment du Snapshot : pas de panique
+	if n := len(l.Errors()); n != 0 {
+		t.Fatalf("after Reset and Restore: %d errors, want 0", n)
+	}
+
+	// Les erreurs rendues avant un Restore ne sont pas écrasées par les
+	// suivantes.
+	l = NewLexer("¤ a ¤ ¤")
+	l.NextToken()
+	s = l.Snapshot()
+	l.Tokenize()
+	before := l.Errors()
+	l.Restore(s)
+	l.SetFilename("again.dsl")
+	l.Tokenize()
+	if len(before) != 3 || before[1].Filename != "" || len(l.Errors()) != 3 || l.Errors()[1].Filename != "again.dsl" {
+		t.Errorf("errors before Restore %v, after %v", before, l.Errors())
+	}
+}
+
+func TestDeclare(t *testing.T) {
+	tokens := NewLexer("x := 5").Tokenize()
+	want := []Token{
+		{Type: TOKEN_IDENTIFIER, Value: "x"},
+		{Type: TOKEN_DECLARE, Value: ":="},
+		{Type: TOKEN_NUMBER, Value: "5"},
+		{Type: TOKEN_EOF},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %+v, want IDENTIFIER, DECLARE, NUMBER", tokens)
+	}
+	for i, w := range want {
+		if tokens[i].Type != w.Type || tokens[i].Value != w.Value {
+			t.Errorf("token %d: got %v %q, want %v %q", i, tokens[i].Type, tokens[i].Value, w.Type, w.Value)
+		}
+	}
+}
+
+func TestTokenLineIsStartLine(t *testing.T) {
+	tok := NewLexer("(* two\nline *) x").NextToken()
+	if tok.Type != TOKEN_IDENTIFIER || tok.Line != 2 || tok.Column != 9 {
+		t.Errorf("identifier after comment: got %v at %d:%d, want IDENTIFIER at 2:9", tok.Type, tok.Line, tok.Column)
+	}
+
+	tokens := NewLexer("a\nb\n  \"one\ntwo\nthree\" c").Tokenize()
+	if len(tokens) != 5 {
+		t.Fatalf("got %+v, want a, b, string, c, EOF", tokens)
+	}
+	if s := tokens[2]; s.Type != TOKEN_STRING || s.Line != 3 || s.Column != 3 || s.EndLine != 5 {
+		t.Errorf("string: got %v at %d:%d ending on line %d, want STRING at 3:3 ending on line 5",
+			s.Type, s.Line, s.Column, s.EndLine)
+	}
+	if c := tokens[3]; c.Line != 5 || c.Column != 8 {
+		t.Errorf("identifier after string: got %d:%d, want 5:8", c.Line, c.Column)
+	}
+}
+
+func TestPeekAtNegative(t *testing.T) {
+	l := NewLexer("a b")
+	if tok := l.PeekAt(-1); tok.Value != "a" {
+		t.Errorf("PeekAt(-1) = %+v, want the next token \"a\"", tok)
+	}
+	if tok := l.NextToken(); tok.Value != "a" {
+		t.Errorf("NextToken after PeekAt(-1) = %+v, want \"a\"", tok)
+	}
+}
+
+func TestUnterminatedCommentIsIllegal(t *testing.T) {
+	for src, want := range map[string]TokenType{
+		"(* abc *)":  TOKEN_COMMENT,
+		"(* abc":     TOKEN_ILLEGAL,
+		"(* (* a *)": TOKEN_ILLEGAL,
+		"// abc":     TOKEN_COMMENT,
+	} {
+		l := NewLexer(src)
+		l.SetEmitComments(true)
+		if tok := l.NextToken(); tok.Type != want || tok.Value != src {
+			t.Errorf("%q: got %v %q, want %v %q", src, tok.Type, tok.Value, want, src)
+		}
+	}
+}